@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/datasource"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/notifier"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/VictoriaMetrics/metricsql"
+)
+
+var resendDelay = flag.Duration("notifier.resendDelay", time.Minute, "Minimum amount of time to wait before resending an alert to notifier")
+
+// AlertingRule is basic alert entity
+type AlertingRule struct {
+	RuleName    string
+	Expr        string
+	For         time.Duration
+	Labels      map[string]string
+	Annotations map[string]string
+
+	group *Group
+
+	// guard status fields
+	mu sync.RWMutex
+	// stores list of active alerts
+	alerts map[uint64]*notifier.Alert
+	// stores last moment of time Eval was called
+	lastExecTime time.Time
+	// stores last error that happened in Eval func
+	// resets on every successful Eval
+	// may be used as Health state
+	lastExecError error
+	// stores the duration of the last Eval call
+	lastExecDuration time.Duration
+}
+
+func newAlertingRule(g *Group, rc ruleConfig) (*AlertingRule, error) {
+	ar := &AlertingRule{
+		RuleName:    rc.Alert,
+		Expr:        rc.Expr,
+		For:         rc.For,
+		Labels:      rc.Labels,
+		Annotations: rc.Annotations,
+		group:       g,
+		alerts:      make(map[uint64]*notifier.Alert),
+	}
+	if err := ar.Validate(); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// Name returns the name of the alerting rule
+func (ar *AlertingRule) Name() string {
+	return ar.RuleName
+}
+
+// evalDurationHistogram returns the per-rule evaluation-duration
+// histogram, lazily created and keyed by rule+group so operators can
+// spot slow rules individually via /metrics.
+func (ar *AlertingRule) evalDurationHistogram() *metrics.Histogram {
+	return metrics.GetOrCreateHistogram(fmt.Sprintf(`vmalert_rule_eval_duration_seconds{alertname=%q, group=%q}`, ar.RuleName, ar.group.Name))
+}
+
+// Validate validates rule
+func (ar *AlertingRule) Validate() error {
+	if ar.RuleName == "" {
+		return errors.New("rule name can not be empty")
+	}
+	if ar.Expr == "" {
+		return fmt.Errorf("expression for rule %q can't be empty", ar.RuleName)
+	}
+	if _, err := metricsql.Parse(ar.Expr); err != nil {
+		return fmt.Errorf("invalid expression for rule %q: %w", ar.RuleName, err)
+	}
+	return nil
+}
+
+// Eval executes AlertingRule expression via the given Querier.
+// Based on the Querier results AlertingRule maintains notifier.Alerts
+func (ar *AlertingRule) Eval(ctx context.Context, q datasource.Querier) error {
+	start := time.Now()
+	ts := ar.group.evalTimestamp()
+	qMetrics, err := q.Query(ctx, ar.Expr, ts)
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	ar.lastExecError = err
+	ar.lastExecTime = ts
+	ar.lastExecDuration = time.Since(start)
+	ar.evalDurationHistogram().Update(ar.lastExecDuration.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to execute query %q: %s", ar.Expr, err)
+	}
+
+	for h, a := range ar.alerts {
+		// cleanup inactive alerts from previous Eval
+		if a.State == notifier.StateInactive {
+			delete(ar.alerts, h)
+		}
+	}
+
+	updated := make(map[uint64]struct{})
+	// update list of active alerts
+	for _, m := range qMetrics {
+		a, err := ar.newAlert(m, q, ts)
+		if err != nil {
+			// template errors degrade gracefully: newAlert already
+			// falls back to the raw template strings, so just record
+			// the error instead of failing the whole evaluation.
+			ar.lastExecError = err
+		}
+		// identity is hashed over the final (post-template) alert
+		// labels, not the raw query-result labels, since that's the
+		// only label set Restore can recover from ALERTS_FOR_STATE.
+		h := hashLabels(a.Labels)
+		updated[h] = struct{}{}
+		if _, ok := ar.alerts[h]; ok {
+			continue
+		}
+		a.ID = h
+		a.State = notifier.StatePending
+		ar.alerts[h] = a
+	}
+
+	for h, a := range ar.alerts {
+		// if alert wasn't updated in this iteration
+		// means it is resolved already
+		if _, ok := updated[h]; !ok {
+			a.State = notifier.StateInactive
+			// set endTime to last execution time
+			// so it can be sent by notifier on next step
+			a.End = ar.lastExecTime
+			continue
+		}
+		if a.State == notifier.StatePending && ar.lastExecTime.Sub(a.Start) >= ar.For {
+			a.State = notifier.StateFiring
+			alertsFired.Inc()
+		}
+		if a.State == notifier.StateFiring {
+			a.End = ar.lastExecTime.Add(3 * *evaluationInterval)
+		}
+	}
+	return nil
+}
+
+// Restore restores the `for` state of pending/firing alerts after a
+// vmalert restart or config reload by querying back the ALERTS_FOR_STATE
+// series this rule previously wrote.
+func (ar *AlertingRule) Restore(ctx context.Context, q datasource.Querier, lookback time.Duration) error {
+	if ar.For == 0 {
+		return nil
+	}
+	ts := ar.group.evalTimestamp()
+	expr := fmt.Sprintf("last_over_time(%s{%s=%q%s}[%s])",
+		alertForStateMetricName, alertNameLabel, ar.RuleName, labelsMatchers(ar.Labels), lookback)
+	qMetrics, err := q.Query(ctx, expr, ts)
+	if err != nil {
+		return fmt.Errorf("failed to restore state for rule %q: %s", ar.RuleName, err)
+	}
+
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for _, m := range qMetrics {
+		// the ALERTS_FOR_STATE series carries exactly the final,
+		// post-template alert labels (see alertForToTimeSeries), so
+		// stripping __name__/alertname recovers the same label set
+		// Eval hashes alerts under - no re-templating needed here.
+		labels := make(map[string]string, len(m.Labels))
+		for _, l := range m.Labels {
+			if l.Name == "__name__" || l.Name == alertNameLabel {
+				continue
+			}
+			labels[l.Name] = l.Value
+		}
+		h := hashLabels(labels)
+		if _, ok := ar.alerts[h]; ok {
+			continue
+		}
+
+		activeAt := time.Unix(int64(m.Value), 0)
+		// restore grace period: don't resurrect alerts whose `for`
+		// window plus the resend delay has already elapsed.
+		if ts.Sub(activeAt) > ar.For+*resendDelay {
+			continue
+		}
+
+		a := &notifier.Alert{
+			ID:     h,
+			Group:  ar.group.Name,
+			Name:   ar.RuleName,
+			Labels: labels,
+			Start:  activeAt,
+			State:  notifier.StatePending,
+		}
+		annotations, err := a.ExecTemplate(q, ts, ar.Annotations)
+		if err != nil {
+			// degrade gracefully: keep the restored alert with its raw
+			// template strings rather than dropping it entirely.
+			ar.lastExecError = err
+			annotations = ar.Annotations
+		}
+		a.Annotations = annotations
+		ar.alerts[h] = a
+	}
+	return nil
+}
+
+func labelsMatchers(labels map[string]string) string {
+	var s string
+	for k, v := range labels {
+		s += fmt.Sprintf(",%s=%q", k, v)
+	}
+	return s
+}
+
+// alertsToSend returns the alerts that should be pushed to the notifier
+// at time ts: pending alerts are never sent, firing alerts are only
+// (re)sent once resendDelay has elapsed since they were last sent, and
+// alerts that just transitioned to inactive are always sent once so the
+// notifier can resolve them. It does not mark the alerts as sent - the
+// caller must call markAlertsSent once the POST to the notifier has
+// actually succeeded, otherwise a failed send would be silently
+// suppressed for a full resendDelay.
+func (ar *AlertingRule) alertsToSend(ts time.Time, resendDelay time.Duration) []notifier.Alert {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	var alerts []notifier.Alert
+	for _, a := range ar.alerts {
+		switch {
+		case a.State == notifier.StatePending:
+			continue
+		case a.State == notifier.StateFiring && ts.Sub(a.LastSentAt) < resendDelay:
+			continue
+		}
+		alerts = append(alerts, *a)
+	}
+	return alerts
+}
+
+// markAlertsSent records ts as the LastSentAt of every alert in sent.
+// Call it only after the notifier has successfully accepted the POST
+// built from the alertsToSend result.
+func (ar *AlertingRule) markAlertsSent(ts time.Time, sent []notifier.Alert) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for _, s := range sent {
+		if a, ok := ar.alerts[s.ID]; ok {
+			a.LastSentAt = ts
+		}
+	}
+}
+
+// Series returns the ALERTS and ALERTS_FOR_STATE timeseries produced by
+// the current set of active alerts, ready to be pushed to remote-write.
+func (ar *AlertingRule) Series() []prompbmarshal.TimeSeries {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	var tss []prompbmarshal.TimeSeries
+	for _, a := range ar.alerts {
+		tss = append(tss, ar.AlertToTimeSeries(a, ar.lastExecTime)...)
+	}
+	return tss
+}
+
+// hashLabels hashes a label map. It is the alert identity function: two
+// alerts with the same label set (post-templating) are the same alert,
+// regardless of which tick (or a Restore) produced them.
+func hashLabels(labels map[string]string) uint64 {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hash := fnv.New64a()
+	for _, k := range keys {
+		hash.Write([]byte(k))
+		hash.Write([]byte(labels[k]))
+		hash.Write([]byte("\xff"))
+	}
+	return hash.Sum64()
+}
+
+// newAlert builds a notifier.Alert out of the given datapoint, templating
+// labels and annotations with the full Prometheus template language -
+// $labels, $value and the query "<expr>" function all resolve against q
+// as of ts. A template error doesn't fail the alert: it's recorded and
+// returned so the caller can surface it as the rule's health, while the
+// raw (untemplated) string is kept so the alert still fires.
+func (ar *AlertingRule) newAlert(m datasource.Metric, q datasource.Querier, ts time.Time) (*notifier.Alert, error) {
+	a := &notifier.Alert{
+		Group:  ar.group.Name,
+		Name:   ar.RuleName,
+		Labels: map[string]string{},
+		Value:  m.Value,
+		Start:  ts,
+		// TODO: support End time
+	}
+
+	// 1. use data labels
+	for _, l := range m.Labels {
+		a.Labels[l.Name] = l.Value
+	}
+
+	// 2. template rule labels with data labels
+	var firstErr error
+	rLabels, err := a.ExecTemplate(q, ts, ar.Labels)
+	if err != nil {
+		firstErr = err
+		rLabels = ar.Labels
+	}
+
+	// 3. merge data labels and rule labels
+	// metric labels may be overridden by
+	// rule labels
+	for k, v := range rLabels {
+		a.Labels[k] = v
+	}
+
+	// 4. template merged labels
+	mergedLabels, err := a.ExecTemplate(q, ts, a.Labels)
+	if err != nil {
+		if firstErr == nil {
+			firstErr = err
+		}
+	} else {
+		a.Labels = mergedLabels
+	}
+
+	annotations, err := a.ExecTemplate(q, ts, ar.Annotations)
+	if err != nil {
+		if firstErr == nil {
+			firstErr = err
+		}
+		annotations = ar.Annotations
+	}
+	a.Annotations = annotations
+	return a, firstErr
+}
+
+// AlertAPI generates APIAlert object from alert by its id(hash)
+func (ar *AlertingRule) AlertAPI(id uint64) *APIAlert {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	a, ok := ar.alerts[id]
+	if !ok {
+		return nil
+	}
+	return ar.newAlertAPI(*a)
+}
+
+// AlertsAPI generates list of APIAlert objects from existing alerts
+func (ar *AlertingRule) AlertsAPI() []*APIAlert {
+	var alerts []*APIAlert
+	ar.mu.RLock()
+	for _, a := range ar.alerts {
+		alerts = append(alerts, ar.newAlertAPI(*a))
+	}
+	ar.mu.RUnlock()
+	return alerts
+}
+
+func (ar *AlertingRule) newAlertAPI(a notifier.Alert) *APIAlert {
+	lastErr := ""
+	if ar.lastExecError != nil {
+		lastErr = ar.lastExecError.Error()
+	}
+	return &APIAlert{
+		ID:          a.ID,
+		Name:        a.Name,
+		Group:       a.Group,
+		Expression:  ar.Expr,
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+		State:       a.State.String(),
+		ActiveAt:    a.Start,
+		Value:       strconv.FormatFloat(a.Value, 'e', -1, 64),
+		Health:      ruleHealth(ar.lastExecTime, ar.lastExecError),
+		LastError:   lastErr,
+	}
+}
+
+// ToAPI returns the rule health/metadata as exposed by /api/v1/rules.
+func (ar *AlertingRule) ToAPI() APIRule {
+	lastErr := ""
+	if ar.lastExecError != nil {
+		lastErr = ar.lastExecError.Error()
+	}
+	return APIRule{
+		Name:           ar.RuleName,
+		Group:          ar.group.Name,
+		Type:           "alerting",
+		Expression:     ar.Expr,
+		Labels:         ar.Labels,
+		Health:         ruleHealth(ar.lastExecTime, ar.lastExecError),
+		LastError:      lastErr,
+		LastEvaluation: ar.lastExecTime,
+		EvaluationTime: ar.lastExecDuration.Seconds(),
+	}
+}
+
+const (
+	// alertMetricName is the metric name for synthetic alert timeseries.
+	alertMetricName = "ALERTS"
+	// alertForStateMetricName is the metric name for 'for' state of alert.
+	alertForStateMetricName = "ALERTS_FOR_STATE"
+
+	// alertNameLabel is the label name indicating the name of an alert.
+	alertNameLabel = "alertname"
+	// alertStateLabel is the label name indicating the state of an alert.
+	alertStateLabel = "alertstate"
+)
+
+// AlertToTimeSeries converts the given alert with the given timestamp to timeseries
+func (ar *AlertingRule) AlertToTimeSeries(a *notifier.Alert, timestamp time.Time) []prompbmarshal.TimeSeries {
+	var tss []prompbmarshal.TimeSeries
+	tss = append(tss, alertToTimeSeries(ar.RuleName, a, timestamp))
+	if ar.For > 0 {
+		tss = append(tss, alertForToTimeSeries(ar.RuleName, a, timestamp))
+	}
+	return tss
+}
+
+func alertToTimeSeries(name string, a *notifier.Alert, timestamp time.Time) prompbmarshal.TimeSeries {
+	labels := make(map[string]string)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["__name__"] = alertMetricName
+	labels[alertNameLabel] = name
+	labels[alertStateLabel] = a.State.String()
+	return newTimeSeries(1, labels, timestamp)
+}
+
+func alertForToTimeSeries(name string, a *notifier.Alert, timestamp time.Time) prompbmarshal.TimeSeries {
+	labels := make(map[string]string)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["__name__"] = alertForStateMetricName
+	labels[alertNameLabel] = name
+	return newTimeSeries(float64(a.Start.Unix()), labels, timestamp)
+}
+
+func newTimeSeries(value float64, labels map[string]string, timestamp time.Time) prompbmarshal.TimeSeries {
+	ts := prompbmarshal.TimeSeries{}
+	ts.Samples = append(ts.Samples, prompbmarshal.Sample{
+		Value:     value,
+		Timestamp: timestamp.UnixNano() / 1e6,
+	})
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		ts.Labels = append(ts.Labels, prompbmarshal.Label{
+			Name:  key,
+			Value: labels[key],
+		})
+	}
+	return ts
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/datasource"
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/notifier"
+)
+
+type fakeQuerier struct {
+	query func(ctx context.Context, q string, ts time.Time) ([]datasource.Metric, error)
+}
+
+func (fq *fakeQuerier) Query(ctx context.Context, q string, ts time.Time) ([]datasource.Metric, error) {
+	return fq.query(ctx, q, ts)
+}
+
+// TestAlertingRuleAlertsToSend ensures pending alerts are never sent,
+// firing alerts are resent only after resendDelay, and that selecting
+// alerts to send doesn't itself mark them as sent - LastSentAt must only
+// move forward once markAlertsSent is called for a successful POST.
+func TestAlertingRuleAlertsToSend(t *testing.T) {
+	now := time.Now()
+	ar := &AlertingRule{
+		RuleName: "HighLatency",
+		group:    &Group{Name: "group"},
+		alerts: map[uint64]*notifier.Alert{
+			1: {ID: 1, State: notifier.StatePending},
+			2: {ID: 2, State: notifier.StateFiring, LastSentAt: now.Add(-2 * time.Minute)},
+			3: {ID: 3, State: notifier.StateFiring, LastSentAt: now},
+		},
+	}
+
+	toSend := ar.alertsToSend(now, time.Minute)
+	if len(toSend) != 1 || toSend[0].ID != 2 {
+		t.Fatalf("expected only alert 2 (pending excluded, recently-sent excluded), got %+v", toSend)
+	}
+	if !ar.alerts[2].LastSentAt.Equal(now.Add(-2 * time.Minute)) {
+		t.Fatalf("alertsToSend must not mutate LastSentAt before the POST succeeds, got %v", ar.alerts[2].LastSentAt)
+	}
+
+	ar.markAlertsSent(now, toSend)
+	if !ar.alerts[2].LastSentAt.Equal(now) {
+		t.Fatalf("expected markAlertsSent to stamp LastSentAt, got %v", ar.alerts[2].LastSentAt)
+	}
+}
+
+// TestAlertingRuleRestoreHashConsistency ensures that a rule with
+// `labels:` restores an alert under the same identity Eval would use
+// for the very same series, so ActiveAt survives a restart instead of
+// producing a phantom pending alert alongside a freshly-pending one.
+func TestAlertingRuleRestoreHashConsistency(t *testing.T) {
+	g := &Group{Name: "group"}
+	ar := &AlertingRule{
+		RuleName: "HighLatency",
+		Expr:     "foo > 1",
+		For:      time.Minute,
+		Labels:   map[string]string{"severity": "page"},
+		group:    g,
+		alerts:   make(map[uint64]*notifier.Alert),
+	}
+
+	dataMetric := datasource.Metric{
+		Labels: []datasource.Label{
+			{Name: "instance", Value: "a"},
+			{Name: "job", Value: "x"},
+		},
+		Value: 5,
+	}
+	dataQuerier := &fakeQuerier{
+		query: func(_ context.Context, _ string, _ time.Time) ([]datasource.Metric, error) {
+			return []datasource.Metric{dataMetric}, nil
+		},
+	}
+
+	if err := ar.Eval(context.Background(), dataQuerier); err != nil {
+		t.Fatalf("unexpected error from Eval: %s", err)
+	}
+	if len(ar.alerts) != 1 {
+		t.Fatalf("expected exactly 1 alert after Eval, got %d", len(ar.alerts))
+	}
+	var original *notifier.Alert
+	for _, a := range ar.alerts {
+		original = a
+	}
+
+	// simulate a restart: a brand-new rule, and a querier that serves
+	// back exactly what Eval would have written to ALERTS_FOR_STATE.
+	ar2 := &AlertingRule{
+		RuleName: ar.RuleName,
+		Expr:     ar.Expr,
+		For:      ar.For,
+		Labels:   ar.Labels,
+		group:    g,
+		alerts:   make(map[uint64]*notifier.Alert),
+	}
+	restoreMetric := alertForToTimeSeries(ar.RuleName, original, time.Now())
+	restoreLabels := make([]datasource.Label, 0, len(restoreMetric.Labels))
+	for _, l := range restoreMetric.Labels {
+		restoreLabels = append(restoreLabels, datasource.Label{Name: l.Name, Value: l.Value})
+	}
+	restoreQuerier := &fakeQuerier{
+		query: func(_ context.Context, _ string, _ time.Time) ([]datasource.Metric, error) {
+			return []datasource.Metric{{Labels: restoreLabels, Value: float64(original.Start.Unix())}}, nil
+		},
+	}
+
+	if err := ar2.Restore(context.Background(), restoreQuerier, time.Hour); err != nil {
+		t.Fatalf("unexpected error from Restore: %s", err)
+	}
+	if len(ar2.alerts) != 1 {
+		t.Fatalf("expected exactly 1 restored alert, got %d", len(ar2.alerts))
+	}
+
+	// the next Eval against live data must land on the very same alert
+	// Restore created, not create a second, fresh-pending one.
+	if err := ar2.Eval(context.Background(), dataQuerier); err != nil {
+		t.Fatalf("unexpected error from Eval after Restore: %s", err)
+	}
+	if len(ar2.alerts) != 1 {
+		t.Fatalf("expected exactly 1 alert after Eval following Restore, got %d (restore hash and eval hash disagree)", len(ar2.alerts))
+	}
+	// ALERTS_FOR_STATE stores ActiveAt as whole Unix seconds, so that's
+	// the precision it survives a restore with.
+	want := time.Unix(original.Start.Unix(), 0)
+	for _, a := range ar2.alerts {
+		if !a.Start.Equal(want) {
+			t.Fatalf("ActiveAt didn't survive restore: got %v, want %v", a.Start, want)
+		}
+	}
+}
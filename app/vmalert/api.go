@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// APIRule represents a Rule (alerting or recording) the way it's
+// rendered by the `/api/v1/rules` endpoint, mirroring Prometheus's
+// rule health reporting.
+type APIRule struct {
+	Name           string            `json:"name"`
+	Group          string            `json:"group"`
+	Type           string            `json:"type"`
+	Expression     string            `json:"query"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Health         string            `json:"health"`
+	LastError      string            `json:"lastError,omitempty"`
+	LastEvaluation time.Time         `json:"lastEvaluation"`
+	EvaluationTime float64           `json:"evaluationTime"`
+}
+
+// health states reported on APIAlert.Health / APIRule.Health
+const (
+	healthOK      = "ok"
+	healthErr     = "err"
+	healthUnknown = "unknown"
+)
+
+// ruleHealth derives a health string from the last error seen
+// during evaluation.
+func ruleHealth(lastExecTime time.Time, lastExecError error) string {
+	if lastExecTime.IsZero() {
+		return healthUnknown
+	}
+	if lastExecError != nil {
+		return healthErr
+	}
+	return healthOK
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/datasource"
+)
+
+var queryOffset = flag.Duration("rule.queryOffset", 0, "The offset in the past that vmalert will apply to each rule's evaluation time. "+
+	"Can be overridden per-group via rule_query_offset. Useful to avoid evaluating rules on partial scrape data.")
+
+var remoteReadURL = flag.String("remoteRead.url", "", "Optional URL to VictoriaMetrics or vmselect that will be used to restore alerts state. "+
+	"This configuration makes sense only if vmalert was configured with `remoteWrite.url` before and has been successfully persisted its state. "+
+	"Supports the same flags as `datasource.url`")
+
+// Group grouping array of alert
+type Group struct {
+	Name  string
+	Rules []Rule
+
+	// QueryOffset shifts the time at which every Rule in this group
+	// queries the datasource, i.e. rules are evaluated as of
+	// time.Now().Add(-QueryOffset) instead of the current instant.
+	QueryOffset time.Duration
+}
+
+// evalTimestamp returns the timestamp the group's rules should
+// query the datasource at.
+func (g *Group) evalTimestamp() time.Time {
+	return time.Now().Add(-g.QueryOffset)
+}
+
+// Restore restores the state of every rule in the group that has
+// something to restore (e.g. pending alerts). It is a no-op unless
+// -remoteRead.url is set, since restoring requires reading back
+// previously written state from a VM read endpoint.
+func (g *Group) Restore(ctx context.Context, q datasource.Querier, lookback time.Duration) error {
+	if *remoteReadURL == "" {
+		return nil
+	}
+	for _, r := range g.Rules {
+		if err := r.Restore(ctx, q, lookback); err != nil {
+			return fmt.Errorf("error while restoring rule %q in group %q: %w", r.Name(), g.Name, err)
+		}
+	}
+	return nil
+}
+
+// copyState copies alerts state and health metadata from rules in
+// old into the matching rules of g, so that a config reload doesn't
+// reset pending/firing alerts. A rule in old matches a rule in g
+// only if both its Name and its full, sorted label set are equal -
+// matching by name alone would cross-pollinate state between two
+// rules that share an alert name but carry different labels.
+func (g *Group) copyState(old *Group) {
+	oldRules := make(map[string]Rule, len(old.Rules))
+	for _, r := range old.Rules {
+		oldRules[ruleStateKey(r)] = r
+	}
+	for _, r := range g.Rules {
+		or, ok := oldRules[ruleStateKey(r)]
+		if !ok {
+			continue
+		}
+		switch nr := r.(type) {
+		case *AlertingRule:
+			o, ok := or.(*AlertingRule)
+			if !ok {
+				continue
+			}
+			o.mu.RLock()
+			nr.mu.Lock()
+			nr.alerts = o.alerts
+			nr.lastExecTime = o.lastExecTime
+			nr.lastExecError = o.lastExecError
+			nr.mu.Unlock()
+			o.mu.RUnlock()
+		case *RecordingRule:
+			o, ok := or.(*RecordingRule)
+			if !ok {
+				continue
+			}
+			o.mu.RLock()
+			nr.mu.Lock()
+			nr.lastExecTime = o.lastExecTime
+			nr.lastExecError = o.lastExecError
+			nr.mu.Unlock()
+			o.mu.RUnlock()
+		}
+	}
+}
+
+// ruleStateKey returns the (name, sorted labels) identity of a rule,
+// used by copyState to find the matching rule across reloads.
+func ruleStateKey(r Rule) string {
+	var labels map[string]string
+	switch rr := r.(type) {
+	case *AlertingRule:
+		labels = rr.Labels
+	case *RecordingRule:
+		labels = rr.Labels
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	key := r.Name()
+	for _, k := range keys {
+		key += "," + k + "=" + labels[k]
+	}
+	return key
+}
+
+// groupConfig is the raw YAML representation of a Group,
+// used only for unmarshaling since a rule entry may describe
+// either an alerting rule (`alert:`) or a recording rule (`record:`).
+type groupConfig struct {
+	Name        string         `yaml:"name"`
+	QueryOffset *time.Duration `yaml:"rule_query_offset"`
+	Rules       []ruleConfig   `yaml:"rules"`
+}
+
+// ruleConfig is the union of fields accepted for a single
+// entry under `rules:` - exactly one of Alert or Record must
+// be set.
+type ruleConfig struct {
+	Alert       string            `yaml:"alert"`
+	Record      string            `yaml:"record"`
+	Expr        string            `yaml:"expr"`
+	For         time.Duration     `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, translating each rule entry
+// into either an *AlertingRule or a *RecordingRule depending on whether
+// it carries an `alert:` or a `record:` key.
+func (g *Group) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var gc groupConfig
+	if err := unmarshal(&gc); err != nil {
+		return err
+	}
+	g.Name = gc.Name
+	if gc.QueryOffset != nil {
+		g.QueryOffset = *gc.QueryOffset
+	} else {
+		g.QueryOffset = *queryOffset
+	}
+	for i, rc := range gc.Rules {
+		switch {
+		case rc.Alert != "" && rc.Record != "":
+			return fmt.Errorf("rule %d in group %q: only one of `alert` or `record` can be set", i, g.Name)
+		case rc.Alert != "":
+			ar, err := newAlertingRule(g, rc)
+			if err != nil {
+				return fmt.Errorf("rule %d in group %q: %w", i, g.Name, err)
+			}
+			g.Rules = append(g.Rules, ar)
+		case rc.Record != "":
+			rr, err := newRecordingRule(g, rc)
+			if err != nil {
+				return fmt.Errorf("rule %d in group %q: %w", i, g.Name, err)
+			}
+			g.Rules = append(g.Rules, rr)
+		default:
+			return fmt.Errorf("rule %d in group %q: either `alert` or `record` must be set", i, g.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/notifier"
+)
+
+// TestGroupCopyStateByNameAndLabels ensures copyState matches rules by
+// (name, labels) identity, not by name alone, so that two rules sharing
+// an alert name but carrying different labels don't cross-pollinate
+// their alerts on reload.
+func TestGroupCopyStateByNameAndLabels(t *testing.T) {
+	oldGroup := &Group{Name: "group"}
+	matching := &AlertingRule{
+		RuleName: "HighLatency",
+		Labels:   map[string]string{"team": "a"},
+		group:    oldGroup,
+		alerts:   map[uint64]*notifier.Alert{1: {ID: 1, Name: "HighLatency"}},
+	}
+	other := &AlertingRule{
+		RuleName: "HighLatency",
+		Labels:   map[string]string{"team": "b"},
+		group:    oldGroup,
+		alerts:   map[uint64]*notifier.Alert{2: {ID: 2, Name: "HighLatency"}},
+	}
+	oldGroup.Rules = []Rule{matching, other}
+
+	newGroup := &Group{Name: "group"}
+	newMatching := &AlertingRule{
+		RuleName: "HighLatency",
+		Labels:   map[string]string{"team": "a"},
+		group:    newGroup,
+		alerts:   make(map[uint64]*notifier.Alert),
+	}
+	newOther := &AlertingRule{
+		RuleName: "HighLatency",
+		Labels:   map[string]string{"team": "b"},
+		group:    newGroup,
+		alerts:   make(map[uint64]*notifier.Alert),
+	}
+	newGroup.Rules = []Rule{newMatching, newOther}
+
+	newGroup.copyState(oldGroup)
+
+	if _, ok := newMatching.alerts[1]; !ok {
+		t.Fatalf("expected rule with team=a to inherit alert 1, got %v", newMatching.alerts)
+	}
+	if _, ok := newOther.alerts[2]; !ok {
+		t.Fatalf("expected rule with team=b to inherit alert 2, got %v", newOther.alerts)
+	}
+	if _, ok := newMatching.alerts[2]; ok {
+		t.Fatalf("rule with team=a must not inherit alert 2 from the team=b rule")
+	}
+}
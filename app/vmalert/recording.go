@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/datasource"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/prompbmarshal"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/VictoriaMetrics/metricsql"
+)
+
+// RecordingRule is a Rule that evaluates an expression periodically
+// and stores the result under the configured Record metric name.
+type RecordingRule struct {
+	RuleName string
+	Expr     string
+	Labels   map[string]string
+
+	group *Group
+
+	// guard status fields
+	mu sync.RWMutex
+	// stores the timeseries produced by the last successful Eval
+	series []prompbmarshal.TimeSeries
+	// stores last moment of time Eval was called
+	lastExecTime time.Time
+	// stores last error that happened in Eval func
+	// resets on every successful Eval
+	// may be used as Health state
+	lastExecError error
+	// stores the duration of the last Eval call
+	lastExecDuration time.Duration
+}
+
+func newRecordingRule(g *Group, rc ruleConfig) (*RecordingRule, error) {
+	rr := &RecordingRule{
+		RuleName: rc.Record,
+		Expr:     rc.Expr,
+		Labels:   rc.Labels,
+		group:    g,
+	}
+	if err := rr.Validate(); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// Name returns the name of the recording rule, i.e. the metric
+// name its result is stored under.
+func (rr *RecordingRule) Name() string {
+	return rr.RuleName
+}
+
+// evalDurationHistogram returns the per-rule evaluation-duration
+// histogram, lazily created and keyed by rule+group so operators can
+// spot slow rules individually via /metrics.
+func (rr *RecordingRule) evalDurationHistogram() *metrics.Histogram {
+	return metrics.GetOrCreateHistogram(fmt.Sprintf(`vmalert_rule_eval_duration_seconds{record=%q, group=%q}`, rr.RuleName, rr.group.Name))
+}
+
+// Validate validates the recording rule
+func (rr *RecordingRule) Validate() error {
+	if rr.RuleName == "" {
+		return errors.New("rule name can not be empty")
+	}
+	if rr.Expr == "" {
+		return fmt.Errorf("expression for rule %q can't be empty", rr.RuleName)
+	}
+	if _, err := metricsql.Parse(rr.Expr); err != nil {
+		return fmt.Errorf("invalid expression for rule %q: %w", rr.RuleName, err)
+	}
+	return nil
+}
+
+// Eval executes RecordingRule expression via the given Querier, producing
+// one timeseries per returned metric with __name__ set to rr.RuleName and
+// rule labels applied on top of (overriding) the sample labels.
+func (rr *RecordingRule) Eval(ctx context.Context, q datasource.Querier) error {
+	start := time.Now()
+	ts := rr.group.evalTimestamp()
+	qMetrics, err := q.Query(ctx, rr.Expr, ts)
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.lastExecError = err
+	rr.lastExecTime = ts
+	rr.lastExecDuration = time.Since(start)
+	rr.evalDurationHistogram().Update(rr.lastExecDuration.Seconds())
+	if err != nil {
+		rr.series = nil
+		return fmt.Errorf("failed to execute query %q: %s", rr.Expr, err)
+	}
+
+	tss := make([]prompbmarshal.TimeSeries, 0, len(qMetrics))
+	for _, m := range qMetrics {
+		tss = append(tss, rr.toTimeSeries(m, rr.lastExecTime))
+	}
+	rr.series = tss
+	return nil
+}
+
+func (rr *RecordingRule) toTimeSeries(m datasource.Metric, timestamp time.Time) prompbmarshal.TimeSeries {
+	labels := make(map[string]string)
+	for _, l := range m.Labels {
+		labels[l.Name] = l.Value
+	}
+	// rule labels override sample labels
+	for k, v := range rr.Labels {
+		labels[k] = v
+	}
+	labels["__name__"] = rr.RuleName
+	return newTimeSeries(m.Value, labels, timestamp)
+}
+
+// Restore is a no-op for RecordingRule, which keeps no alert state
+// that needs restoring across restarts or reloads.
+func (rr *RecordingRule) Restore(_ context.Context, _ datasource.Querier, _ time.Duration) error {
+	return nil
+}
+
+// Series returns the timeseries produced by the last Eval call, ready
+// to be pushed through the remote-write pipeline.
+func (rr *RecordingRule) Series() []prompbmarshal.TimeSeries {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	return rr.series
+}
+
+// ToAPI returns the rule health/metadata as exposed by /api/v1/rules.
+func (rr *RecordingRule) ToAPI() APIRule {
+	lastErr := ""
+	if rr.lastExecError != nil {
+		lastErr = rr.lastExecError.Error()
+	}
+	return APIRule{
+		Name:           rr.RuleName,
+		Group:          rr.group.Name,
+		Type:           "recording",
+		Expression:     rr.Expr,
+		Labels:         rr.Labels,
+		Health:         ruleHealth(rr.lastExecTime, rr.lastExecError),
+		LastError:      lastErr,
+		LastEvaluation: rr.lastExecTime,
+		EvaluationTime: rr.lastExecDuration.Seconds(),
+	}
+}
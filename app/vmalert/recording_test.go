@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/app/vmalert/datasource"
+)
+
+func TestNewRecordingRuleValidation(t *testing.T) {
+	g := &Group{Name: "group"}
+	cases := []ruleConfig{
+		{Record: "", Expr: "up"},
+		{Record: "up:count", Expr: ""},
+		{Record: "up:count", Expr: "up{"},
+	}
+	for _, rc := range cases {
+		if _, err := newRecordingRule(g, rc); err == nil {
+			t.Errorf("expected newRecordingRule(%+v) to fail validation, got nil error", rc)
+		}
+	}
+
+	rc := ruleConfig{Record: "up:count", Expr: "count(up)", Labels: map[string]string{"team": "sre"}}
+	rr, err := newRecordingRule(g, rc)
+	if err != nil {
+		t.Fatalf("unexpected error from a valid recording rule: %s", err)
+	}
+	if rr.Name() != "up:count" {
+		t.Fatalf("unexpected rule name %q", rr.Name())
+	}
+}
+
+// TestRecordingRuleToTimeSeries covers the recording rule's series
+// generation: one timeseries per metric with __name__ set to the
+// record name, and rule labels overriding sample labels.
+func TestRecordingRuleToTimeSeries(t *testing.T) {
+	rr := &RecordingRule{
+		RuleName: "up:count",
+		Labels:   map[string]string{"team": "sre", "job": "override-me"},
+	}
+	m := datasource.Metric{
+		Labels: []datasource.Label{
+			{Name: "job", Value: "original"},
+			{Name: "instance", Value: "a"},
+		},
+		Value: 3,
+	}
+	ts := rr.toTimeSeries(m, time.Now())
+
+	got := map[string]string{}
+	for _, l := range ts.Labels {
+		got[l.Name] = l.Value
+	}
+	if got["__name__"] != "up:count" {
+		t.Fatalf("expected __name__=up:count, got %q", got["__name__"])
+	}
+	if got["job"] != "override-me" {
+		t.Fatalf("expected rule label to override sample label, got job=%q", got["job"])
+	}
+	if got["instance"] != "a" {
+		t.Fatalf("expected sample label to survive, got instance=%q", got["instance"])
+	}
+	if got["team"] != "sre" {
+		t.Fatalf("expected rule label to be applied, got team=%q", got["team"])
+	}
+	if len(ts.Samples) != 1 || ts.Samples[0].Value != 3 {
+		t.Fatalf("unexpected sample: %+v", ts.Samples)
+	}
+}